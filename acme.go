@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// acmeChallengeTTL is the TTL applied to the TXT record created for a
+// DNS-01 challenge. It only needs to be short-lived, so propagation delay
+// stays low.
+const acmeChallengeTTL = 300
+
+// acmeState is persisted to disk so that acme-present and acme-cleanup, run
+// as separate processes by certbot's --manual-auth-hook/--manual-cleanup-hook,
+// can agree on which zone version holds the challenge and which version to
+// restore once it is no longer needed.
+type acmeState struct {
+	ZoneId      int    `json:"zone_id"`
+	OrigVersion int    `json:"orig_version"`
+	NewVersion  int    `json:"new_version"`
+	AuthZone    string `json:"auth_zone"`
+	Pending     int    `json:"pending"`
+}
+
+func acmeStatePath(dir string, zoneId int) string {
+	return filepath.Join(dir, fmt.Sprintf("acme-%d.json", zoneId))
+}
+
+func loadAcmeState(path string) (*acmeState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &acmeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func saveAcmeState(path string, state *acmeState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// txtRecordName builds the name of the challenge TXT record relative to
+// authZone, e.g. "_acme-challenge.www" for fqdn "www.example.org" and
+// authZone "example.org".
+func txtRecordName(fqdn, authZone string) string {
+	name := strings.TrimSuffix(fqdn, ".")
+	name = strings.TrimSuffix(name, "."+authZone)
+	if name == authZone {
+		return "_acme-challenge"
+	}
+	return "_acme-challenge." + name
+}
+
+func runAcmePresent(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: gandi-dyn acme-present <apikey> <fqdn> <token-value>")
+	}
+	apiKey, fqdn, value := args[0], args[1], args[2]
+
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	// acme-present/acme-cleanup rely on copying, activating and restoring
+	// zone versions to stage and tear down the challenge record; LiveDNS has
+	// no such concept (CopyZoneVersion/SetZoneVersion/DeleteZoneVersion are
+	// no-ops there), so force the xmlrpc backend regardless of key shape.
+	api, err := NewGandiAPI(apiKey, "xmlrpc")
+	if err != nil {
+		return err
+	}
+	authZone, err := findAuthZone(fqdn)
+	if err != nil {
+		return err
+	}
+	zoneId, err := api.GetZoneId(authZone)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockZone(dir, zoneId)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	path := acmeStatePath(dir, zoneId)
+	state, err := loadAcmeState(path)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		origVersion, err := api.GetActiveZoneVersion(zoneId)
+		if err != nil {
+			return err
+		}
+		newVersion, err := api.CopyZoneVersion(zoneId)
+		if err != nil {
+			return err
+		}
+		state = &acmeState{
+			ZoneId:      zoneId,
+			OrigVersion: origVersion,
+			NewVersion:  newVersion,
+			AuthZone:    authZone,
+		}
+	} else {
+		// A second present before any cleanup (e.g. certbot staging a
+		// wildcard + base domain cert) finds state.NewVersion already
+		// active from the first call. Gandi refuses edits to the active
+		// version, so stage a fresh one and keep OrigVersion pointing at
+		// what was active before the first present.
+		active, err := api.GetActiveZoneVersion(zoneId)
+		if err != nil {
+			return err
+		}
+		if active == state.NewVersion {
+			newVersion, err := api.CopyZoneVersion(zoneId)
+			if err != nil {
+				return err
+			}
+			state.NewVersion = newVersion
+		}
+	}
+
+	record := Record{
+		Type:  "TXT",
+		Name:  txtRecordName(fqdn, authZone),
+		Value: value,
+		TTL:   acmeChallengeTTL,
+	}
+	if _, err := api.AddRecord(zoneId, state.NewVersion, record); err != nil {
+		return err
+	}
+	state.Pending++
+	if err := saveAcmeState(path, state); err != nil {
+		return err
+	}
+	fmt.Println("added challenge record", record.Name, "to zone version", state.NewVersion)
+	return api.SetZoneVersion(zoneId, state.NewVersion)
+}
+
+func runAcmeCleanup(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: gandi-dyn acme-cleanup <apikey> <fqdn>")
+	}
+	apiKey, fqdn := args[0], args[1]
+
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	// acme-present/acme-cleanup rely on copying, activating and restoring
+	// zone versions to stage and tear down the challenge record; LiveDNS has
+	// no such concept (CopyZoneVersion/SetZoneVersion/DeleteZoneVersion are
+	// no-ops there), so force the xmlrpc backend regardless of key shape.
+	api, err := NewGandiAPI(apiKey, "xmlrpc")
+	if err != nil {
+		return err
+	}
+	authZone, err := findAuthZone(fqdn)
+	if err != nil {
+		return err
+	}
+	zoneId, err := api.GetZoneId(authZone)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockZone(dir, zoneId)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	path := acmeStatePath(dir, zoneId)
+	state, err := loadAcmeState(path)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		fmt.Println("no pending challenge found for", fqdn)
+		return nil
+	}
+
+	state.Pending--
+	if state.Pending > 0 {
+		return saveAcmeState(path, state)
+	}
+
+	if err := api.SetZoneVersion(zoneId, state.OrigVersion); err != nil {
+		return err
+	}
+	if err := api.DeleteZoneVersion(zoneId, state.NewVersion); err != nil {
+		fmt.Println("failed to delete temporary zone version:", err)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Target names one record to keep in sync within a zone, e.g. Name "home"
+// in Domain "example.com" for home.example.com, or "@" for the zone apex.
+type Target struct {
+	Domain string `json:"domain"`
+	Name   string `json:"name"`
+}
+
+func loadTargets(path string) ([]Target, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	targets := []Target{}
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	for i, t := range targets {
+		if t.Domain == "" {
+			return nil, fmt.Errorf("target %d is missing a domain", i)
+		}
+		if t.Name == "" {
+			targets[i].Name = "@"
+		}
+	}
+	return targets, nil
+}
+
+// groupByDomain returns, for each domain referenced by targets, the set of
+// record names to keep in sync within that zone.
+func groupByDomain(targets []Target) map[string]map[string]bool {
+	groups := map[string]map[string]bool{}
+	for _, t := range targets {
+		names, ok := groups[t.Domain]
+		if !ok {
+			names = map[string]bool{}
+			groups[t.Domain] = names
+		}
+		names[t.Name] = true
+	}
+	return groups
+}
+
+// cleanupOldVersions deletes inactive zone versions beyond the most recent
+// retain of them, so a zone synced repeatedly does not accumulate orphaned
+// versions forever. Failures are logged rather than returned since they
+// should not block the update that just succeeded.
+func cleanupOldVersions(api GandiAPI, zoneId, retain int) {
+	versions, err := api.ListZoneVersions(zoneId)
+	if err != nil {
+		fmt.Println("failed to list zone versions for cleanup:", err)
+		return
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Id > versions[j].Id })
+	kept := 0
+	for _, v := range versions {
+		if v.Active {
+			continue
+		}
+		kept++
+		if kept <= retain {
+			continue
+		}
+		if err := api.DeleteZoneVersion(zoneId, v.Id); err != nil {
+			fmt.Println("failed to delete orphaned zone version", v.Id, ":", err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ipCache remembers the last addresses successfully published, so the
+// daemon can skip a round of Gandi API calls when discovery reports the
+// same address again after a restart.
+type ipCache struct {
+	IPv4 string `json:"ipv4"`
+	IPv6 string `json:"ipv6"`
+}
+
+func ipCachePath(dir string) string {
+	return filepath.Join(dir, "daemon-ip-cache.json")
+}
+
+func loadIPCache(path string) (*ipCache, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &ipCache{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache := &ipCache{}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+func saveIPCache(path string, cache *ipCache) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	interval := fs.Duration("interval", 5*time.Minute, "how often to check for IP changes")
+	backend := fs.String("backend", "", "Gandi API backend to use: xmlrpc, livedns, or empty to auto-detect")
+	listen := fs.String("listen", ":9140", "address to serve /metrics on")
+	ipv4Only := fs.Bool("ipv4-only", false, "only manage IPv4 (A) records")
+	ipv6Only := fs.Bool("ipv6-only", false, "only manage IPv6 (AAAA) records")
+	ipv4Source := fs.String("ipv4-source", defaultIPv4Source,
+		"URL returning {\"ip\": ...} used to discover the public IPv4 address")
+	ipv6Source := fs.String("ipv6-source", defaultIPv6Source,
+		"URL returning {\"ip\": ...} used to discover the public IPv6 address")
+	iface := fs.String("interface", "",
+		"discover addresses from this local network interface instead of --ipv4-source/--ipv6-source")
+	retainVersions := fs.Int("retain-versions", 3,
+		"number of prior inactive zone versions to keep; older ones are deleted after each update")
+	fs.Parse(args)
+	rest := fs.Args()
+	if len(rest) < 2 {
+		return fmt.Errorf("usage: gandi-dyn daemon [flags] <apikey> <domain>")
+	}
+	if *ipv4Only && *ipv6Only {
+		return fmt.Errorf("--ipv4-only and --ipv6-only are mutually exclusive")
+	}
+	apiKey, domain := rest[0], rest[1]
+
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	cache, err := loadIPCache(ipCachePath(dir))
+	if err != nil {
+		return err
+	}
+
+	api, err := NewGandiAPI(apiKey, *backend)
+	if err != nil {
+		return err
+	}
+	metrics := newMetrics()
+	go serveMetrics(*listen, metrics)
+
+	backoff := newBackoff(*interval)
+	for {
+		err := daemonTick(api, domain, *ipv4Only, *ipv6Only, *iface, *ipv4Source, *ipv6Source, cache, dir, metrics, *retainVersions)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "err:", err)
+			metrics.recordError()
+			time.Sleep(backoff.next())
+			continue
+		}
+		backoff.reset()
+		time.Sleep(*interval)
+	}
+}
+
+// daemonTick runs a single poll/update cycle: discover the current
+// addresses, skip Gandi entirely if they match the cache, and otherwise
+// reconcile the zone and persist the new cache.
+func daemonTick(api GandiAPI, domain string, ipv4Only, ipv6Only bool, iface, ipv4Source, ipv6Source string,
+	cache *ipCache, dir string, metrics *daemonMetrics, retainVersions int) error {
+
+	var ip4, ip6 string
+	if !ipv6Only {
+		addr, err := discoverIP(4, iface, ipv4Source)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipv4 discovery failed, leaving A records untouched:", err)
+		} else {
+			ip4 = addr
+		}
+	}
+	if !ipv4Only {
+		addr, err := discoverIP(6, iface, ipv6Source)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipv6 discovery failed, leaving AAAA records untouched:", err)
+		} else {
+			ip6 = addr
+		}
+	}
+	if ip4 == "" && ip6 == "" {
+		return fmt.Errorf("could not discover an IPv4 or IPv6 address")
+	}
+
+	if ip4 == cache.IPv4 && ip6 == cache.IPv6 {
+		return nil
+	}
+
+	zoneId, err := api.GetZoneId(domain)
+	if err != nil {
+		return err
+	}
+	newVersion, err := api.CopyZoneVersion(zoneId)
+	if err != nil {
+		return err
+	}
+	if err := updateRecords(api, zoneId, newVersion, nil, ip4, ip6); err != nil {
+		if err2 := api.DeleteZoneVersion(zoneId, newVersion); err2 != nil {
+			fmt.Fprintln(os.Stderr, "zone version deletion failed:", err2)
+		}
+		return err
+	}
+	if err := api.SetZoneVersion(zoneId, newVersion); err != nil {
+		return err
+	}
+	cleanupOldVersions(api, zoneId, retainVersions)
+
+	cache.IPv4, cache.IPv6 = ip4, ip6
+	if err := saveIPCache(ipCachePath(dir), cache); err != nil {
+		return err
+	}
+	metrics.recordUpdate(ip4, ip6)
+	fmt.Println("zone updated", "ipv4", ip4, "ipv6", ip6)
+	return nil
+}
+
+// backoff tracks an exponential delay between failed daemon ticks, capped at
+// a multiple of the normal poll interval so a persistent outage does not
+// leave the daemon waiting for hours.
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(interval time.Duration) *backoff {
+	return &backoff{base: interval, max: 32 * interval, current: interval}
+}
+
+func (b *backoff) next() time.Duration {
+	delay := b.current
+	b.current *= 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+	return delay
+}
+
+func (b *backoff) reset() {
+	b.current = b.base
+}
+
+func serveMetrics(addr string, metrics *daemonMetrics) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.handler)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Fprintln(os.Stderr, "metrics server failed:", err)
+	}
+}
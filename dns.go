@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const dnsTypeSOA = 6
+const dnsClassIN = 1
+
+// resolverAddr returns the first nameserver listed in /etc/resolv.conf,
+// falling back to a public resolver if none can be found.
+func resolverAddr() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "8.8.8.8:53"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "8.8.8.8:53"
+}
+
+// encodeDNSName encodes a dotted domain name into DNS wire-format labels.
+func encodeDNSName(name string) []byte {
+	name = strings.Trim(name, ".")
+	buf := []byte{}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+	}
+	return append(buf, 0)
+}
+
+// hasSOA reports whether name is the apex of a DNS zone, i.e. whether
+// querying it for a SOA record returns a successful response with at least
+// one answer.
+func hasSOA(name string) (bool, error) {
+	id := uint16(os.Getpid())
+	query := make([]byte, 12)
+	binary.BigEndian.PutUint16(query[0:], id)
+	binary.BigEndian.PutUint16(query[2:], 0x0100) // standard query, recursion desired
+	binary.BigEndian.PutUint16(query[4:], 1)      // QDCOUNT
+	query = append(query, encodeDNSName(name)...)
+	question := make([]byte, 4)
+	binary.BigEndian.PutUint16(question[0:], dnsTypeSOA)
+	binary.BigEndian.PutUint16(question[2:], dnsClassIN)
+	query = append(query, question...)
+
+	conn, err := net.DialTimeout("udp", resolverAddr(), 5*time.Second)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(query); err != nil {
+		return false, err
+	}
+
+	resp := make([]byte, 512)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return false, err
+	}
+	if n < 12 {
+		return false, fmt.Errorf("truncated DNS response for %s", name)
+	}
+	if binary.BigEndian.Uint16(resp[0:]) != id {
+		return false, fmt.Errorf("DNS response id mismatch for %s", name)
+	}
+	rcode := resp[3] & 0x0f
+	ancount := binary.BigEndian.Uint16(resp[6:])
+	return rcode == 0 && ancount > 0, nil
+}
+
+// findAuthZone walks up the labels of fqdn, querying DNS for a SOA record at
+// each suffix, until it finds the zone apex authoritative for fqdn.
+func findAuthZone(fqdn string) (string, error) {
+	name := strings.TrimSuffix(fqdn, ".")
+	for {
+		ok, err := hasSOA(name)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return name, nil
+		}
+		idx := strings.Index(name, ".")
+		if idx < 0 {
+			return "", fmt.Errorf("could not find a DNS zone for %s", fqdn)
+		}
+		name = name[idx+1:]
+	}
+}
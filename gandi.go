@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GandiAPI abstracts the Gandi zone-management API so the rest of gandi-dyn
+// can run against either backend Gandi ships: the legacy XML-RPC API
+// (explicit copy/edit/activate zone versions) or the newer LiveDNS REST API
+// (direct record CRUD, no versioning). Record changes made against a
+// version number other than the live one are staged; version 0 always
+// means "the currently active records".
+type GandiAPI interface {
+	GetZoneId(domain string) (int, error)
+	GetZoneRecords(zoneId, version int) ([]Record, error)
+	CopyZoneVersion(zoneId int) (int, error)
+	DeleteRecord(zoneId, version int, id string) (int, error)
+	AddRecord(zoneId, version int, record Record) (Record, error)
+	SetZoneVersion(zoneId, version int) error
+	DeleteZoneVersion(zoneId, version int) error
+	ListZoneVersions(zoneId int) ([]ZoneVersion, error)
+	GetActiveZoneVersion(zoneId int) (int, error)
+}
+
+// NewGandiAPI builds a GandiAPI client for the requested backend, one of
+// "xmlrpc", "livedns", or "" to auto-detect it from the credential: LiveDNS
+// personal access tokens are long opaque strings, while XML-RPC API keys are
+// short hex handles.
+func NewGandiAPI(apiKey, backend string) (GandiAPI, error) {
+	if backend == "" {
+		backend = detectBackend(apiKey)
+	}
+	switch backend {
+	case "livedns":
+		return newLiveDNSAPI(apiKey), nil
+	case "xmlrpc":
+		return newXMLRPCAPI(apiKey)
+	default:
+		return nil, fmt.Errorf("unknown backend %q, expected xmlrpc or livedns", backend)
+	}
+}
+
+func detectBackend(apiKey string) string {
+	if len(apiKey) > 40 || strings.Contains(apiKey, ".") {
+		return "livedns"
+	}
+	return "xmlrpc"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultIPv4Source = "https://api.ipify.org?format=json"
+	defaultIPv6Source = "https://api6.ipify.org?format=json"
+)
+
+// discoverIP returns the public address gandi-dyn should publish for the
+// given address family (4 or 6). If iface is set, it is used instead of
+// calling out to source.
+func discoverIP(family int, iface, source string) (string, error) {
+	if iface != "" {
+		return interfaceIP(iface, family)
+	}
+	return fetchIP(source, family)
+}
+
+// fetchIP queries a third-party discovery service returning {"ip": ...} and
+// checks that the result is an address of the requested family.
+func fetchIP(source string, family int) (string, error) {
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	rsp, err := client.Get(source)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("http call failed with %d", rsp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return "", err
+	}
+	parsed := struct {
+		IP string `json:"ip"`
+	}{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return "", err
+	}
+	return checkIPFamily(parsed.IP, family)
+}
+
+// interfaceIP returns the first address of the requested family configured
+// on the named local interface, skipping loopback and link-local addresses.
+func interfaceIP(name string, family int) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if _, err := checkIPFamily(ip.String(), family); err == nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv%d address found on interface %s", family, name)
+}
+
+// checkIPFamily validates that value parses as an IP address of the
+// requested family (4 or 6).
+func checkIPFamily(value string, family int) (string, error) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "", fmt.Errorf("does not look like an IP address: %s", value)
+	}
+	isV4 := ip.To4() != nil
+	if family == 4 && !isV4 {
+		return "", fmt.Errorf("expected an IPv4 address, got %s", value)
+	}
+	if family == 6 && isV4 {
+		return "", fmt.Errorf("expected an IPv6 address, got %s", value)
+	}
+	return value, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const liveDNSBaseURL = "https://api.gandi.net/v5/livedns"
+
+// liveDNSAPI implements GandiAPI against Gandi's LiveDNS REST API. LiveDNS
+// has no notion of zone versions: record changes apply directly, so the
+// version-staging methods are no-ops and every zone only ever has version 0,
+// already active.
+type liveDNSAPI struct {
+	key    string
+	client *http.Client
+
+	mu      sync.Mutex
+	domains map[int]string
+}
+
+func newLiveDNSAPI(apiKey string) *liveDNSAPI {
+	return &liveDNSAPI{
+		key:     apiKey,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		domains: map[int]string{},
+	}
+}
+
+// zoneIdForDomain derives a stable GandiAPI zone id for a LiveDNS domain,
+// which the REST API otherwise addresses by name rather than by numeric id.
+func zoneIdForDomain(domain string) int {
+	h := fnv.New32a()
+	h.Write([]byte(domain))
+	return int(h.Sum32())
+}
+
+func (api *liveDNSAPI) GetZoneId(domain string) (int, error) {
+	if _, err := api.do("GET", "/domains/"+domain+"/records", nil); err != nil {
+		return 0, err
+	}
+	id := zoneIdForDomain(domain)
+	api.mu.Lock()
+	api.domains[id] = domain
+	api.mu.Unlock()
+	return id, nil
+}
+
+func (api *liveDNSAPI) domainName(zoneId int) (string, error) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	domain, ok := api.domains[zoneId]
+	if !ok {
+		return "", fmt.Errorf("unknown zone id %d, call GetZoneId first", zoneId)
+	}
+	return domain, nil
+}
+
+type liveDNSRRSet struct {
+	Name   string   `json:"rrset_name"`
+	Type   string   `json:"rrset_type"`
+	TTL    int      `json:"rrset_ttl"`
+	Values []string `json:"rrset_values"`
+}
+
+// liveDNSRecordRef identifies a single value within an rrset. LiveDNS has no
+// per-value id of its own (DELETE/PUT address a whole rrset by name+type),
+// so Record.Id is this struct, opaquely encoded, letting DeleteRecord
+// reconcile just the targeted value against the rrset's other values.
+type liveDNSRecordRef struct {
+	Name  string `json:"n"`
+	Type  string `json:"t"`
+	Value string `json:"v"`
+}
+
+func liveDNSRecordId(name, typ, value string) string {
+	data, err := json.Marshal(liveDNSRecordRef{Name: name, Type: typ, Value: value})
+	if err != nil {
+		panic(err) // liveDNSRecordRef only holds strings, marshaling cannot fail
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseLiveDNSRecordId(id string) (liveDNSRecordRef, error) {
+	data, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return liveDNSRecordRef{}, fmt.Errorf("malformed livedns record id %q: %s", id, err)
+	}
+	ref := liveDNSRecordRef{}
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return liveDNSRecordRef{}, fmt.Errorf("malformed livedns record id %q: %s", id, err)
+	}
+	return ref, nil
+}
+
+func (api *liveDNSAPI) GetZoneRecords(zoneId, version int) ([]Record, error) {
+	domain, err := api.domainName(zoneId)
+	if err != nil {
+		return nil, err
+	}
+	data, err := api.do("GET", "/domains/"+domain+"/records", nil)
+	if err != nil {
+		return nil, err
+	}
+	rrsets := []liveDNSRRSet{}
+	if err := json.Unmarshal(data, &rrsets); err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	for _, rrset := range rrsets {
+		for _, value := range rrset.Values {
+			records = append(records, Record{
+				Id:    liveDNSRecordId(rrset.Name, rrset.Type, value),
+				Type:  rrset.Type,
+				Name:  rrset.Name,
+				Value: value,
+				TTL:   rrset.TTL,
+			})
+		}
+	}
+	return records, nil
+}
+
+func (api *liveDNSAPI) CopyZoneVersion(zoneId int) (int, error) {
+	return 0, nil
+}
+
+func (api *liveDNSAPI) SetZoneVersion(zoneId, version int) error {
+	return nil
+}
+
+func (api *liveDNSAPI) DeleteZoneVersion(zoneId, version int) error {
+	return nil
+}
+
+func (api *liveDNSAPI) ListZoneVersions(zoneId int) ([]ZoneVersion, error) {
+	return []ZoneVersion{{Id: 0, Active: true}}, nil
+}
+
+func (api *liveDNSAPI) GetActiveZoneVersion(zoneId int) (int, error) {
+	return 0, nil
+}
+
+func (api *liveDNSAPI) AddRecord(zoneId, version int, record Record) (Record, error) {
+	domain, err := api.domainName(zoneId)
+	if err != nil {
+		return Record{}, err
+	}
+	// LiveDNS replaces the whole rrset on PUT, so fetch its current values
+	// first and append to them instead of clobbering the rest of the set.
+	rrset, err := api.getRRSet(domain, record.Name, record.Type)
+	if err != nil {
+		return Record{}, err
+	}
+	values := appendUniqueValue(rrset.Values, record.Value)
+	if err := api.putRRSet(domain, record.Name, record.Type, record.TTL, values); err != nil {
+		return Record{}, err
+	}
+	record.Id = liveDNSRecordId(record.Name, record.Type, record.Value)
+	return record, nil
+}
+
+// DeleteRecord removes a single value from its rrset, re-PUTting the
+// remaining values (or DELETEing the rrset outright once it is empty),
+// since LiveDNS otherwise only knows how to replace or delete a whole rrset.
+func (api *liveDNSAPI) DeleteRecord(zoneId, version int, id string) (int, error) {
+	domain, err := api.domainName(zoneId)
+	if err != nil {
+		return 0, err
+	}
+	ref, err := parseLiveDNSRecordId(id)
+	if err != nil {
+		return 0, err
+	}
+	rrset, err := api.getRRSet(domain, ref.Name, ref.Type)
+	if err != nil {
+		return 0, err
+	}
+	remaining := []string{}
+	found := false
+	for _, v := range rrset.Values {
+		if v == ref.Value && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, v)
+	}
+	if !found {
+		return 0, nil
+	}
+	if len(remaining) == 0 {
+		path := fmt.Sprintf("/domains/%s/records/%s/%s", domain, ref.Name, ref.Type)
+		if _, err := api.do("DELETE", path, nil); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+	if err := api.putRRSet(domain, ref.Name, ref.Type, rrset.TTL, remaining); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func appendUniqueValue(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// getRRSet fetches the current TTL and values of an rrset, returning a zero
+// liveDNSRRSet (no error) if it does not exist yet.
+func (api *liveDNSAPI) getRRSet(domain, name, typ string) (liveDNSRRSet, error) {
+	path := fmt.Sprintf("/domains/%s/records/%s/%s", domain, name, typ)
+	data, err := api.do("GET", path, nil)
+	if err != nil {
+		if isLiveDNSNotFound(err) {
+			return liveDNSRRSet{}, nil
+		}
+		return liveDNSRRSet{}, err
+	}
+	rrset := liveDNSRRSet{}
+	if err := json.Unmarshal(data, &rrset); err != nil {
+		return liveDNSRRSet{}, err
+	}
+	return rrset, nil
+}
+
+func (api *liveDNSAPI) putRRSet(domain, name, typ string, ttl int, values []string) error {
+	body, err := json.Marshal(liveDNSRRSet{TTL: ttl, Values: values})
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/domains/%s/records/%s/%s", domain, name, typ)
+	_, err = api.do("PUT", path, body)
+	return err
+}
+
+type liveDNSNotFoundError struct {
+	path string
+}
+
+func (e *liveDNSNotFoundError) Error() string {
+	return fmt.Sprintf("not found: %s", e.path)
+}
+
+func isLiveDNSNotFound(err error) bool {
+	_, ok := err.(*liveDNSNotFoundError)
+	return ok
+}
+
+func (api *liveDNSAPI) do(method, path string, body []byte) ([]byte, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, liveDNSBaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+api.key)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rsp, err := api.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, &liveDNSNotFoundError{path: path}
+	}
+	if rsp.StatusCode >= 300 {
+		return nil, fmt.Errorf("livedns api call %s %s failed with %d: %s", method, path, rsp.StatusCode, data)
+	}
+	return data, nil
+}
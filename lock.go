@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// zoneLock serializes operations against a single Gandi zone across
+// processes, so concurrent acme-present/acme-cleanup invocations for the
+// same zone (e.g. certbot requesting a wildcard certificate, which needs two
+// challenges) share one challenge state file instead of racing. A present
+// that finds the previously staged version already active stages a further
+// copy of it; cleanup deletes only the most recently staged version.
+type zoneLock struct {
+	file *os.File
+}
+
+func lockZone(dir string, zoneId int) (*zoneLock, error) {
+	path := filepath.Join(dir, fmt.Sprintf("zone-%d.lock", zoneId))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &zoneLock{file: f}, nil
+}
+
+func (l *zoneLock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
@@ -1,25 +1,24 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
-	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/kolo/xmlrpc"
 )
 
-type GandiAPI struct {
+// xmlrpcAPI implements GandiAPI against Gandi's legacy XML-RPC API, driving
+// zones through explicit copy/edit/activate versions.
+type xmlrpcAPI struct {
 	key    string
 	client *xmlrpc.Client
 }
 
-func NewGandiAPI(apiKey string) (*GandiAPI, error) {
+func newXMLRPCAPI(apiKey string) (*xmlrpcAPI, error) {
 	transport := http.Transport{
 		ResponseHeaderTimeout: 60 * time.Second,
 	}
@@ -27,13 +26,13 @@ func NewGandiAPI(apiKey string) (*GandiAPI, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &GandiAPI{
+	return &xmlrpcAPI{
 		key:    apiKey,
 		client: client,
 	}, nil
 }
 
-func (api *GandiAPI) GetZoneId(domain string) (int, error) {
+func (api *xmlrpcAPI) GetZoneId(domain string) (int, error) {
 	args := []interface{}{
 		api.key,
 		domain,
@@ -60,7 +59,7 @@ type Record struct {
 	TTL   int    `xmlrpc:"ttl"`
 }
 
-func (api *GandiAPI) GetZoneRecords(zoneId, version int) ([]Record, error) {
+func (api *xmlrpcAPI) GetZoneRecords(zoneId, version int) ([]Record, error) {
 	args := []interface{}{
 		api.key,
 		zoneId,
@@ -71,7 +70,7 @@ func (api *GandiAPI) GetZoneRecords(zoneId, version int) ([]Record, error) {
 	return result, err
 }
 
-func (api *GandiAPI) CopyZoneVersion(zoneId int) (int, error) {
+func (api *xmlrpcAPI) CopyZoneVersion(zoneId int) (int, error) {
 	args := []interface{}{
 		api.key,
 		zoneId,
@@ -81,7 +80,7 @@ func (api *GandiAPI) CopyZoneVersion(zoneId int) (int, error) {
 	return version, err
 }
 
-func (api *GandiAPI) DeleteRecord(zoneId, version int, id string) (int, error) {
+func (api *xmlrpcAPI) DeleteRecord(zoneId, version int, id string) (int, error) {
 	// Documentation states "id" can be int or string, but a string value fails
 	// if the related integer does not fit in 32-bits.
 	intId, err := strconv.ParseInt(id, 10, 64)
@@ -103,7 +102,7 @@ func (api *GandiAPI) DeleteRecord(zoneId, version int, id string) (int, error) {
 	return deleted, err
 }
 
-func (api *GandiAPI) AddRecord(zoneId, version int, record Record) (Record, error) {
+func (api *xmlrpcAPI) AddRecord(zoneId, version int, record Record) (Record, error) {
 	r := NewRecord{
 		Type:  record.Type,
 		Name:  record.Name,
@@ -121,7 +120,7 @@ func (api *GandiAPI) AddRecord(zoneId, version int, record Record) (Record, erro
 	return created, err
 }
 
-func (api *GandiAPI) SetZoneVersion(zoneId, version int) error {
+func (api *xmlrpcAPI) SetZoneVersion(zoneId, version int) error {
 	args := []interface{}{
 		api.key,
 		zoneId,
@@ -138,7 +137,35 @@ func (api *GandiAPI) SetZoneVersion(zoneId, version int) error {
 	return nil
 }
 
-func (api *GandiAPI) DeleteZoneVersion(zoneId, version int) error {
+type ZoneVersion struct {
+	Id     int  `xmlrpc:"id"`
+	Active bool `xmlrpc:"active"`
+}
+
+func (api *xmlrpcAPI) ListZoneVersions(zoneId int) ([]ZoneVersion, error) {
+	args := []interface{}{
+		api.key,
+		zoneId,
+	}
+	versions := []ZoneVersion{}
+	err := api.client.Call("domain.zone.version.list", args, &versions)
+	return versions, err
+}
+
+func (api *xmlrpcAPI) GetActiveZoneVersion(zoneId int) (int, error) {
+	versions, err := api.ListZoneVersions(zoneId)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range versions {
+		if v.Active {
+			return v.Id, nil
+		}
+	}
+	return 0, fmt.Errorf("no active zone version found for zone %d", zoneId)
+}
+
+func (api *xmlrpcAPI) DeleteZoneVersion(zoneId, version int) error {
 	args := []interface{}{
 		api.key,
 		zoneId,
@@ -155,51 +182,33 @@ func (api *GandiAPI) DeleteZoneVersion(zoneId, version int) error {
 	return nil
 }
 
-var (
-	reIP = regexp.MustCompile(`\d+\.\d+\.\d+\.\d+`)
-)
-
-func getMyIP() (string, error) {
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
-	rsp, err := client.Get("https://api.ipify.org?format=json")
-	if err != nil {
-		return "", err
-	}
-	if rsp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("http call failed with %d", rsp.StatusCode)
-	}
-	data, err := ioutil.ReadAll(rsp.Body)
-	if err != nil {
-		return "", err
-	}
-	ip := struct {
-		IP string `json:"ip"`
-	}{}
-	err = json.Unmarshal(data, &ip)
-	if err != nil {
-		return "", err
-	}
-	if !reIP.MatchString(ip.IP) {
-		return "", fmt.Errorf("does not look like an IPv4: %s", ip)
-	}
-	return ip.IP, nil
-}
-
-func updateRecords(api *GandiAPI, records []Record, zoneId, version int,
-	ip string) error {
-
+// updateRecords reconciles A and AAAA records in the given zone version
+// against ip4/ip6 (an empty value leaves that family untouched). If names
+// is non-nil, only records whose name is in it are considered, letting
+// callers target a handful of records rather than the whole zone.
+func updateRecords(api GandiAPI, zoneId, version int, names map[string]bool, ip4, ip6 string) error {
 	records, err := api.GetZoneRecords(zoneId, version)
 	if err != nil {
 		return err
 	}
 	for _, r := range records {
-		if r.Type != "A" || r.Value == ip {
+		if names != nil && !names[r.Name] {
+			continue
+		}
+		var want string
+		switch r.Type {
+		case "A":
+			want = ip4
+		case "AAAA":
+			want = ip6
+		default:
+			continue
+		}
+		if want == "" || r.Value == want {
 			continue
 		}
 		r := r
-		r.Value = ip
+		r.Value = want
 		fmt.Println("updating", r)
 		n, err := api.DeleteRecord(zoneId, version, r.Id)
 		if err != nil {
@@ -216,55 +225,35 @@ func updateRecords(api *GandiAPI, records []Record, zoneId, version int,
 	return nil
 }
 
-func checkIP() error {
-	flag.Usage = func() {
-		fmt.Println(`Usage: gandi-dyn apikey mydomain.org
-
-gandi-dyn fetches A records from Gandi for a domain using their API. If the
-record value differs from the current IP obtained from a third-party service, a
-new zone version is created, updated with the new address and activated.
-`)
-		os.Exit(1)
-	}
-	flag.Parse()
-	if flag.NArg() < 1 {
-		return fmt.Errorf("missing api-key argument")
-	}
-	if flag.NArg() < 2 {
-		return fmt.Errorf("missing domain argument")
-	}
-	key := flag.Arg(0)
-	domain := flag.Arg(1)
-
-	ip, err := getMyIP()
-	if err != nil {
-		return err
-	}
-	fmt.Println(ip)
-	api, err := NewGandiAPI(key)
-	if err != nil {
-		return err
-	}
+// syncZone reconciles the records named in names, within domain's zone,
+// against ip4/ip6, creating and activating exactly one new zone version if
+// any of them drifted, then trimming old inactive versions.
+func syncZone(api GandiAPI, domain string, names map[string]bool, ip4, ip6 string, retainVersions int) error {
 	zoneId, err := api.GetZoneId(domain)
 	if err != nil {
 		return err
 	}
-	fmt.Println("zoneid", zoneId)
 	records, err := api.GetZoneRecords(zoneId, 0)
 	if err != nil {
 		return err
 	}
 
-	newRecords := []Record{}
 	changed := false
 	for _, r := range records {
-		if r.Type == "A" && r.Value != ip {
+		if !names[r.Name] {
+			continue
+		}
+		if r.Type == "A" && ip4 != "" && r.Value != ip4 {
+			changed = true
+			break
+		}
+		if r.Type == "AAAA" && ip6 != "" && r.Value != ip6 {
 			changed = true
 			break
 		}
 	}
 	if !changed {
-		fmt.Println("unchanged ip")
+		fmt.Println(domain, "unchanged")
 		return nil
 	}
 
@@ -272,27 +261,113 @@ new zone version is created, updated with the new address and activated.
 	if err != nil {
 		return err
 	}
-	err = updateRecords(api, newRecords, zoneId, newVersion, ip)
-	if err != nil {
-		fmt.Println("failed to apply records, deleting zone version")
-		err2 := api.DeleteZoneVersion(zoneId, newVersion)
-		if err2 != nil {
-			fmt.Println("zone version deletion failed: %s", err)
+	if err := updateRecords(api, zoneId, newVersion, names, ip4, ip6); err != nil {
+		fmt.Println("failed to apply records for", domain, ", deleting zone version")
+		if err2 := api.DeleteZoneVersion(zoneId, newVersion); err2 != nil {
+			fmt.Println("zone version deletion failed:", err2)
 		}
 		return err
 	}
-	err = api.SetZoneVersion(zoneId, newVersion)
+	if err := api.SetZoneVersion(zoneId, newVersion); err != nil {
+		fmt.Println("zone activation failed for", domain, ":", err)
+		return err
+	}
+	fmt.Println(domain, "zone activated")
+	cleanupOldVersions(api, zoneId, retainVersions)
+	return nil
+}
+
+func checkIP() error {
+	flag.Usage = func() {
+		fmt.Println(`Usage: gandi-dyn apikey targets.json
+
+gandi-dyn fetches A and AAAA records from Gandi for the domains and record
+names listed in targets.json ([{"domain": "example.org", "name": "home"},
+...], "name" defaulting to "@" for the zone apex). If a record value differs
+from the current address obtained from a third-party service, gandi-dyn
+opens one new zone version per affected zone, updates it with the new
+address and activates it.
+`)
+		os.Exit(1)
+	}
+	ipv4Only := flag.Bool("ipv4-only", false, "only manage IPv4 (A) records")
+	ipv6Only := flag.Bool("ipv6-only", false, "only manage IPv6 (AAAA) records")
+	ipv4Source := flag.String("ipv4-source", defaultIPv4Source,
+		"URL returning {\"ip\": ...} used to discover the public IPv4 address")
+	ipv6Source := flag.String("ipv6-source", defaultIPv6Source,
+		"URL returning {\"ip\": ...} used to discover the public IPv6 address")
+	iface := flag.String("interface", "",
+		"discover addresses from this local network interface instead of --ipv4-source/--ipv6-source")
+	backend := flag.String("backend", "", "Gandi API backend to use: xmlrpc, livedns, or empty to auto-detect")
+	retainVersions := flag.Int("retain-versions", 3,
+		"number of prior inactive zone versions to keep per zone; older ones are deleted after each update")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		return fmt.Errorf("missing api-key argument")
+	}
+	if flag.NArg() < 2 {
+		return fmt.Errorf("missing targets file argument")
+	}
+	if *ipv4Only && *ipv6Only {
+		return fmt.Errorf("--ipv4-only and --ipv6-only are mutually exclusive")
+	}
+	key := flag.Arg(0)
+	targets, err := loadTargets(flag.Arg(1))
+	if err != nil {
+		return err
+	}
+	groups := groupByDomain(targets)
+
+	var ip4, ip6 string
+	if !*ipv6Only {
+		ip4, err = discoverIP(4, *iface, *ipv4Source)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipv4 discovery failed, leaving A records untouched:", err)
+			ip4 = ""
+		} else {
+			fmt.Println("ipv4", ip4)
+		}
+	}
+	if !*ipv4Only {
+		ip6, err = discoverIP(6, *iface, *ipv6Source)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipv6 discovery failed, leaving AAAA records untouched:", err)
+			ip6 = ""
+		} else {
+			fmt.Println("ipv6", ip6)
+		}
+	}
+	if ip4 == "" && ip6 == "" {
+		return fmt.Errorf("could not discover an IPv4 or IPv6 address")
+	}
+
+	api, err := NewGandiAPI(key, *backend)
 	if err != nil {
-		fmt.Println("zone activation failed: %s", err)
 		return err
 	}
-	// TODO: remove previous version?
-	fmt.Println("zone activated")
-	return fmt.Errorf("ip changed to %s", ip)
+	for domain, names := range groups {
+		if err := syncZone(api, domain, names, ip4, ip6, *retainVersions); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func main() {
-	err := checkIP()
+	var err error
+	args := os.Args[1:]
+	switch {
+	case len(args) > 0 && args[0] == "acme-present":
+		err = runAcmePresent(args[1:])
+	case len(args) > 0 && args[0] == "acme-cleanup":
+		err = runAcmeCleanup(args[1:])
+	case len(args) > 0 && args[0] == "sync":
+		err = runSync(args[1:])
+	case len(args) > 0 && args[0] == "daemon":
+		err = runDaemon(args[1:])
+	default:
+		err = checkIP()
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "err: %s\n", err)
 		os.Exit(1)
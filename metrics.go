@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// daemonMetrics tracks the state the /metrics endpoint reports: when the
+// zone was last updated, the addresses currently published, and how many
+// polling cycles have failed (on a Gandi API error, an address discovery
+// failure or a cache write failure).
+type daemonMetrics struct {
+	mu          sync.Mutex
+	lastUpdate  time.Time
+	currentIPv4 string
+	currentIPv6 string
+	errorCount  int
+}
+
+func newMetrics() *daemonMetrics {
+	return &daemonMetrics{}
+}
+
+func (m *daemonMetrics) recordUpdate(ip4, ip6 string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastUpdate = time.Now()
+	m.currentIPv4 = ip4
+	m.currentIPv6 = ip6
+}
+
+func (m *daemonMetrics) recordError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorCount++
+}
+
+func (m *daemonMetrics) handler(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP gandi_dyn_last_update_timestamp_seconds Time of the last successful zone update.\n")
+	fmt.Fprintf(w, "# TYPE gandi_dyn_last_update_timestamp_seconds gauge\n")
+	lastUpdate := 0
+	if !m.lastUpdate.IsZero() {
+		lastUpdate = int(m.lastUpdate.Unix())
+	}
+	fmt.Fprintf(w, "gandi_dyn_last_update_timestamp_seconds %d\n", lastUpdate)
+	fmt.Fprintf(w, "# HELP gandi_dyn_current_ip Current address published per family (1 if set, 0 if not).\n")
+	fmt.Fprintf(w, "# TYPE gandi_dyn_current_ip gauge\n")
+	fmt.Fprintf(w, "gandi_dyn_current_ip{family=\"4\",ip=%q} %d\n", m.currentIPv4, boolToFloat(m.currentIPv4 != ""))
+	fmt.Fprintf(w, "gandi_dyn_current_ip{family=\"6\",ip=%q} %d\n", m.currentIPv6, boolToFloat(m.currentIPv6 != ""))
+	fmt.Fprintf(w, "# HELP gandi_dyn_api_errors_total Number of failed API polling cycles.\n")
+	fmt.Fprintf(w, "# TYPE gandi_dyn_api_errors_total counter\n")
+	fmt.Fprintf(w, "gandi_dyn_api_errors_total %d\n", m.errorCount)
+}
+
+func boolToFloat(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// stateDir returns the directory gandi-dyn uses to persist run-time state,
+// such as in-progress ACME challenges, creating it if it does not exist yet.
+// It follows the XDG base directory spec, defaulting to ~/.local/state when
+// XDG_STATE_HOME is unset.
+func stateDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	dir := filepath.Join(base, "gandi-dyn")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+// syncRecordTypes lists the record types the sync subcommand is allowed to
+// manage; anything else in a desired-state file is rejected up front rather
+// than failing partway through a zone version.
+var syncRecordTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"CNAME": true,
+	"MX":    true,
+	"TXT":   true,
+	"SRV":   true,
+	"CAA":   true,
+	"NS":    true,
+}
+
+// desiredRecord is one entry of a sync records file: a plain JSON array of
+// {type, name, value, ttl} objects describing the zone's target state.
+type desiredRecord struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	TTL   int    `json:"ttl"`
+}
+
+func loadDesiredRecords(path string) ([]desiredRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	records := []desiredRecord{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	return records, nil
+}
+
+func recordKey(typ, name, value string) string {
+	return typ + "|" + name + "|" + value
+}
+
+// diffRecords compares the zone's current records against the desired set,
+// keyed on (type, name, value), and returns the records to add and delete to
+// reconcile one into the other.
+func diffRecords(current []Record, desired []desiredRecord) (adds []desiredRecord, deletes []Record) {
+	desiredKeys := map[string]bool{}
+	for _, r := range desired {
+		desiredKeys[recordKey(r.Type, r.Name, r.Value)] = true
+	}
+	currentKeys := map[string]bool{}
+	for _, r := range current {
+		currentKeys[recordKey(r.Type, r.Name, r.Value)] = true
+		if !desiredKeys[recordKey(r.Type, r.Name, r.Value)] {
+			deletes = append(deletes, r)
+		}
+	}
+	for _, r := range desired {
+		if !currentKeys[recordKey(r.Type, r.Name, r.Value)] {
+			adds = append(adds, r)
+		}
+	}
+	return adds, deletes
+}
+
+// applySyncPlan applies adds and deletes to zoneId's version. Record ids are
+// version-specific, so deletes (computed against the active version) are
+// matched against records re-fetched from version rather than reusing the
+// ids diffRecords saw, the same way updateRecords does (main.go).
+func applySyncPlan(api GandiAPI, zoneId, version int, adds []desiredRecord, deletes []Record) error {
+	deleteKeys := map[string]bool{}
+	for _, r := range deletes {
+		deleteKeys[recordKey(r.Type, r.Name, r.Value)] = true
+	}
+	versionRecords, err := api.GetZoneRecords(zoneId, version)
+	if err != nil {
+		return err
+	}
+	for _, r := range versionRecords {
+		if !deleteKeys[recordKey(r.Type, r.Name, r.Value)] {
+			continue
+		}
+		n, err := api.DeleteRecord(zoneId, version, r.Id)
+		if err != nil {
+			return err
+		}
+		if n < 1 {
+			return fmt.Errorf("no record deleted for %s %s", r.Type, r.Name)
+		}
+	}
+	for _, r := range adds {
+		record := Record{Type: r.Type, Name: r.Name, Value: r.Value, TTL: r.TTL}
+		if _, err := api.AddRecord(zoneId, version, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the reconciliation plan without changing the zone")
+	force := fs.Bool("force", false, "proceed even if the plan deletes more than --max-delete-percent of records")
+	maxDeletePercent := fs.Int("max-delete-percent", 50, "refuse to delete more than this percentage of existing records unless --force is set")
+	fs.Parse(args)
+	rest := fs.Args()
+	if len(rest) < 3 {
+		return fmt.Errorf("usage: gandi-dyn sync [--dry-run] [--force] [--max-delete-percent N] <apikey> <domain> <records-file>")
+	}
+	apiKey, domain, path := rest[0], rest[1], rest[2]
+
+	desired, err := loadDesiredRecords(path)
+	if err != nil {
+		return err
+	}
+	for _, r := range desired {
+		if !syncRecordTypes[r.Type] {
+			return fmt.Errorf("unsupported record type %q for %s", r.Type, r.Name)
+		}
+	}
+
+	api, err := NewGandiAPI(apiKey, "")
+	if err != nil {
+		return err
+	}
+	zoneId, err := api.GetZoneId(domain)
+	if err != nil {
+		return err
+	}
+	current, err := api.GetZoneRecords(zoneId, 0)
+	if err != nil {
+		return err
+	}
+
+	adds, deletes := diffRecords(current, desired)
+	fmt.Printf("plan: %d additions, %d deletions\n", len(adds), len(deletes))
+	for _, r := range deletes {
+		fmt.Println("- ", r.Type, r.Name, r.Value)
+	}
+	for _, r := range adds {
+		fmt.Println("+ ", r.Type, r.Name, r.Value)
+	}
+
+	if len(current) > 0 && len(deletes)*100 > len(current)**maxDeletePercent && !*force {
+		return fmt.Errorf("plan deletes %d of %d records (> %d%%), rerun with --force to proceed",
+			len(deletes), len(current), *maxDeletePercent)
+	}
+	if *dryRun {
+		return nil
+	}
+	if len(adds) == 0 && len(deletes) == 0 {
+		fmt.Println("zone already in sync")
+		return nil
+	}
+
+	version, err := api.CopyZoneVersion(zoneId)
+	if err != nil {
+		return err
+	}
+	if err := applySyncPlan(api, zoneId, version, adds, deletes); err != nil {
+		fmt.Println("failed to apply plan, deleting zone version")
+		if err2 := api.DeleteZoneVersion(zoneId, version); err2 != nil {
+			fmt.Println("zone version deletion failed:", err2)
+		}
+		return err
+	}
+	if err := api.SetZoneVersion(zoneId, version); err != nil {
+		return err
+	}
+	fmt.Println("zone synced")
+	return nil
+}